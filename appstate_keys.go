@@ -0,0 +1,62 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"context"
+	"fmt"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/store"
+)
+
+// RequestAppStateKeys asks our other devices to re-share the app state sync keys identified by
+// keyIDs, mirroring what official clients do when they hit an unknown key ID while decoding a
+// patch. Keys that come back in response go through the normal handleAppStateSyncKeyShare path,
+// including dispatching events.AppStateSyncKeyReceived, like any other key share.
+func (cli *Client) RequestAppStateKeys(ctx context.Context, keyIDs [][]byte) error {
+	msg := &waProto.Message{
+		ProtocolMessage: &waProto.ProtocolMessage{
+			Type: waProto.ProtocolMessage_APP_STATE_SYNC_KEY_REQUEST.Enum(),
+			AppStateSyncKeyRequest: &waProto.AppStateSyncKeyRequest{
+				KeyIds: appStateSyncKeyIDMessages(keyIDs),
+			},
+		},
+	}
+	_, err := cli.SendMessage(ctx, cli.Store.ID.ToNonAD(), msg, SendRequestExtra{Peer: true})
+	return err
+}
+
+// appStateSyncKeyIDMessages wraps each raw key ID in the protobuf type RequestAppStateKeys sends
+// them as, split out from RequestAppStateKeys itself so the mapping can be tested without going
+// through SendMessage.
+func appStateSyncKeyIDMessages(keyIDs [][]byte) []*waProto.AppStateSyncKeyId {
+	keyIDMessages := make([]*waProto.AppStateSyncKeyId, len(keyIDs))
+	for i, id := range keyIDs {
+		keyIDMessages[i] = &waProto.AppStateSyncKeyId{KeyId: id}
+	}
+	return keyIDMessages
+}
+
+// AppStateSyncKey pairs an app state sync key with the key ID it's stored under, since
+// store.AppStateSyncKey itself doesn't carry an ID (PutAppStateSyncKey takes it separately).
+type AppStateSyncKey struct {
+	KeyID []byte
+	store.AppStateSyncKey
+}
+
+// ImportAppStateKeys stores pre-existing app state sync keys, e.g. ones exported from another
+// device or backed up out-of-band, without waiting for them to be shared over the wire. This is
+// primarily useful for restoring a wiped store without forcing a brand new key share round-trip.
+func (cli *Client) ImportAppStateKeys(keys []AppStateSyncKey) error {
+	for _, key := range keys {
+		if err := cli.Store.AppStateKeys.PutAppStateSyncKey(key.KeyID, key.AppStateSyncKey); err != nil {
+			return fmt.Errorf("failed to import app state sync key %X: %w", key.KeyID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAppStateSyncKeyIDMessages(t *testing.T) {
+	keyIDs := [][]byte{{0x01, 0x02}, {0x03}}
+	got := appStateSyncKeyIDMessages(keyIDs)
+	if len(got) != len(keyIDs) {
+		t.Fatalf("got %d messages, want %d", len(got), len(keyIDs))
+	}
+	for i, id := range keyIDs {
+		if !bytes.Equal(got[i].GetKeyId(), id) {
+			t.Errorf("message %d key ID = %X, want %X", i, got[i].GetKeyId(), id)
+		}
+	}
+}
+
+func TestAppStateSyncKeyIDMessagesEmpty(t *testing.T) {
+	got := appStateSyncKeyIDMessages(nil)
+	if len(got) != 0 {
+		t.Errorf("got %d messages for nil input, want 0", len(got))
+	}
+}
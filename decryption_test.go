@@ -0,0 +1,105 @@
+// Copyright (c) 2021 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"go.mau.fi/libsignal/signalerror"
+
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+func TestRetryCounterIncrement(t *testing.T) {
+	var counter retryCounter
+	if n := counter.increment("msg1"); n != 1 {
+		t.Errorf("first increment = %d, want 1", n)
+	}
+	if n := counter.increment("msg1"); n != 2 {
+		t.Errorf("second increment = %d, want 2", n)
+	}
+	if n := counter.increment("msg2"); n != 1 {
+		t.Errorf("increment for a different ID = %d, want 1", n)
+	}
+}
+
+func TestRetryCounterClear(t *testing.T) {
+	var counter retryCounter
+	counter.increment("msg1")
+	counter.increment("msg1")
+	counter.clear("msg1")
+	if n := counter.increment("msg1"); n != 1 {
+		t.Errorf("increment after clear = %d, want 1", n)
+	}
+}
+
+func TestEffectiveMaxMessageRetryCount(t *testing.T) {
+	tests := []struct {
+		name string
+		set  int
+		want int
+	}{
+		{"unset", 0, defaultMaxMessageRetryCount},
+		{"negative", -1, defaultMaxMessageRetryCount},
+		{"custom", 10, 10},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cli := &Client{MaxMessageRetryCount: tc.set}
+			if got := cli.effectiveMaxMessageRetryCount(); got != tc.want {
+				t.Errorf("effectiveMaxMessageRetryCount() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRetryCounterReachesGiveUpThreshold(t *testing.T) {
+	var counter retryCounter
+	cli := &Client{MaxMessageRetryCount: 3}
+	maxRetries := cli.effectiveMaxMessageRetryCount()
+
+	var lastCount int
+	for i := 0; i < maxRetries; i++ {
+		lastCount = counter.increment("msg1")
+		if lastCount > maxRetries {
+			t.Fatalf("retry count exceeded threshold after only %d attempts", i+1)
+		}
+	}
+	if lastCount != maxRetries {
+		t.Fatalf("count after %d attempts = %d, want %d", maxRetries, lastCount, maxRetries)
+	}
+
+	// One more failure should push the count past the threshold, which is what makes
+	// handleDecryptionFailure dispatch DecryptionGaveUp instead of UndecryptableMessage.
+	if n := counter.increment("msg1"); n <= maxRetries {
+		t.Fatalf("count after exceeding the threshold = %d, want > %d", n, maxRetries)
+	}
+}
+
+func TestClassifyDecryptionError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want events.DecryptionFailureReason
+	}{
+		{"untrustedIdentity", signalerror.ErrUntrustedIdentity, events.ReasonUntrustedIdentity},
+		{"noSession", signalerror.ErrNoSessionFound, events.ReasonNoSession},
+		{"badPadding", errInvalidPadding, events.ReasonBadPadding},
+		{"wrappedUntrustedIdentity", fmt.Errorf("failed to decrypt prekey message: %w", signalerror.ErrUntrustedIdentity), events.ReasonUntrustedIdentity},
+		{"unknown", errors.New("something else"), events.ReasonUnavailable},
+		{"nil", nil, events.ReasonUnavailable},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := classifyDecryptionError(tc.err); got != tc.want {
+				t.Errorf("classifyDecryptionError(%v) = %q, want %q", tc.err, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/proto"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// defaultHistorySyncStreamThreshold is used when Client.HistorySyncStreamThreshold is unset (zero or negative).
+const defaultHistorySyncStreamThreshold = 10 * 1024 * 1024 // 10 MiB, compressed
+
+// historySyncChunkSize is how many conversations are buffered before dispatching a
+// events.HistorySyncChunk. It's intentionally small so memory usage stays bounded regardless of
+// how many conversations the account has.
+const historySyncChunkSize = 50
+
+// Field numbers of the top-level fields of the HistorySync protobuf message that
+// streamHistorySync cares about; every other field is skipped without being decoded.
+const (
+	historySyncTypeField          = 1
+	historySyncConversationsField = 2
+	historySyncPushnamesField     = 7
+)
+
+// historicalPushNamesHandler processes the push names collected from a PUSH_NAME history sync.
+// It's a package variable instead of a direct method call only so tests can substitute a stub
+// without needing a fully-initialized Client.
+var historicalPushNamesHandler = (*Client).handleHistoricalPushNames
+
+// HistorySyncStorage lets a caller capture a copy of the raw decompressed HistorySync protobuf
+// bytes as they're read, e.g. to persist them to disk. It's written to on both the streaming and
+// the non-streaming path (see Client.HistorySyncStreamThreshold); on the non-streaming path the
+// whole decompressed payload is still read into memory as well.
+type HistorySyncStorage interface {
+	io.Writer
+}
+
+// countingReader wraps a reader and tracks how many bytes have been read from it, so streaming
+// history sync can report rough progress based on compressed bytes consumed.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// streamHistorySync reads a decompressed HistorySync protobuf directly off the wire, one
+// top-level field at a time, dispatching events.HistorySyncChunk as conversations are parsed
+// instead of buffering the whole message tree in memory before dispatching a single
+// events.HistorySync. progress returns an estimate (0-1) of compressed bytes consumed.
+//
+// It also collects push names and, for a HistorySync_PUSH_NAME sync, runs them through
+// handleHistoricalPushNames once the stream ends, mirroring what the non-streaming path in
+// handleHistorySyncNotification does for the same sync type.
+func (cli *Client) streamHistorySync(src io.Reader, progress func() float64) error {
+	if cli.HistorySyncStorage != nil {
+		src = io.TeeReader(src, cli.HistorySyncStorage)
+	}
+	r := &wireReader{r: src}
+
+	var syncType waProto.HistorySync_HistorySyncType
+	var processed int
+	var chunk []*waProto.Conversation
+	var pushNames []*waProto.Pushname
+	chunkIndex := 0
+
+	flush := func() {
+		if len(chunk) == 0 {
+			return
+		}
+		chunkIndex++
+		cli.dispatchEvent(&events.HistorySyncChunk{
+			SyncType:             syncType,
+			ChunkIndex:           chunkIndex,
+			TotalConversations:   processed,
+			ConversationsInChunk: chunk,
+			Progress:             progress(),
+		})
+		chunk = nil
+	}
+
+	for {
+		fieldNum, wireType, err := r.readTag()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read field tag: %w", err)
+		}
+		switch {
+		case fieldNum == historySyncTypeField && wireType == wireTypeVarint:
+			v, err := r.readVarint()
+			if err != nil {
+				return fmt.Errorf("failed to read sync type: %w", err)
+			}
+			syncType = waProto.HistorySync_HistorySyncType(v)
+		case fieldNum == historySyncConversationsField && wireType == wireTypeBytes:
+			data, err := r.readBytes()
+			if err != nil {
+				return fmt.Errorf("failed to read conversation: %w", err)
+			}
+			var conv waProto.Conversation
+			if err = proto.Unmarshal(data, &conv); err != nil {
+				cli.Log.Warnf("Failed to unmarshal conversation in history sync stream: %v", err)
+				continue
+			}
+			processed++
+			chunk = append(chunk, &conv)
+			if len(chunk) >= historySyncChunkSize {
+				flush()
+			}
+		case fieldNum == historySyncPushnamesField && wireType == wireTypeBytes:
+			data, err := r.readBytes()
+			if err != nil {
+				return fmt.Errorf("failed to read push name: %w", err)
+			}
+			var pushName waProto.Pushname
+			if err = proto.Unmarshal(data, &pushName); err != nil {
+				cli.Log.Warnf("Failed to unmarshal push name in history sync stream: %v", err)
+				continue
+			}
+			pushNames = append(pushNames, &pushName)
+		default:
+			if err = r.skipField(wireType); err != nil {
+				return fmt.Errorf("failed to skip field: %w", err)
+			}
+		}
+	}
+	flush()
+	if syncType == waProto.HistorySync_PUSH_NAME {
+		go historicalPushNamesHandler(cli, pushNames)
+	}
+	return nil
+}
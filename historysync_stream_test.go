@@ -0,0 +1,88 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+)
+
+func TestStreamHistorySyncDecodesPushNames(t *testing.T) {
+	origHandler := historicalPushNamesHandler
+	defer func() { historicalPushNamesHandler = origHandler }()
+
+	received := make(chan []*waProto.Pushname, 1)
+	historicalPushNamesHandler = func(cli *Client, names []*waProto.Pushname) {
+		received <- names
+	}
+
+	hs := &waProto.HistorySync{
+		SyncType: waProto.HistorySync_PUSH_NAME.Enum(),
+		Pushnames: []*waProto.Pushname{
+			{ID: proto.String("111"), Pushname: proto.String("Alice")},
+			{ID: proto.String("222"), Pushname: proto.String("Bob")},
+		},
+	}
+	data, err := proto.Marshal(hs)
+	if err != nil {
+		t.Fatalf("failed to marshal test HistorySync: %v", err)
+	}
+
+	cli := &Client{}
+	if err = cli.streamHistorySync(bytes.NewReader(data), func() float64 { return 1 }); err != nil {
+		t.Fatalf("streamHistorySync returned error: %v", err)
+	}
+
+	select {
+	case names := <-received:
+		if len(names) != 2 {
+			t.Fatalf("handler received %d push names, want 2", len(names))
+		}
+		if names[0].GetID() != "111" || names[0].GetPushname() != "Alice" {
+			t.Errorf("push name 0 = %+v, want ID=111 Pushname=Alice", names[0])
+		}
+		if names[1].GetID() != "222" || names[1].GetPushname() != "Bob" {
+			t.Errorf("push name 1 = %+v, want ID=222 Pushname=Bob", names[1])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("historicalPushNamesHandler was never called")
+	}
+}
+
+func TestStreamHistorySyncSkipsPushNamesForOtherSyncTypes(t *testing.T) {
+	origHandler := historicalPushNamesHandler
+	defer func() { historicalPushNamesHandler = origHandler }()
+
+	called := false
+	historicalPushNamesHandler = func(cli *Client, names []*waProto.Pushname) {
+		called = true
+	}
+
+	hs := &waProto.HistorySync{
+		SyncType: waProto.HistorySync_RECENT.Enum(),
+	}
+	data, err := proto.Marshal(hs)
+	if err != nil {
+		t.Fatalf("failed to marshal test HistorySync: %v", err)
+	}
+
+	cli := &Client{}
+	if err = cli.streamHistorySync(bytes.NewReader(data), func() float64 { return 1 }); err != nil {
+		t.Fatalf("streamHistorySync returned error: %v", err)
+	}
+
+	// Give the goroutine a chance to run if it incorrectly fires.
+	time.Sleep(10 * time.Millisecond)
+	if called {
+		t.Error("historicalPushNamesHandler was called for a non-PUSH_NAME sync")
+	}
+}
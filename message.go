@@ -9,11 +9,11 @@ package whatsmeow
 import (
 	"bytes"
 	"compress/zlib"
-	"crypto/rand"
 	"errors"
 	"fmt"
 	"io"
 	"strconv"
+	"sync"
 	"time"
 
 	"go.mau.fi/libsignal/signalerror"
@@ -33,6 +33,36 @@ import (
 
 var pbSerializer = store.SignalProtobufSerializer
 
+// defaultMaxMessageRetryCount is used when Client.MaxMessageRetryCount is unset (zero or negative).
+const defaultMaxMessageRetryCount = 5
+
+// errInvalidPadding is returned by unpadMessage when the trailing padding bytes don't match
+// the on-the-wire padding scheme. It exists mainly so decryption failures can be classified.
+var errInvalidPadding = errors.New("plaintext doesn't have expected padding")
+
+// retryCounter tracks how many times decryption of a given message ID has failed in a row,
+// so callers can tell a first failure from a message that's stuck and will never decrypt.
+type retryCounter struct {
+	sync.Mutex
+	counts map[string]int
+}
+
+func (r *retryCounter) increment(id string) int {
+	r.Lock()
+	defer r.Unlock()
+	if r.counts == nil {
+		r.counts = make(map[string]int)
+	}
+	r.counts[id]++
+	return r.counts[id]
+}
+
+func (r *retryCounter) clear(id string) {
+	r.Lock()
+	defer r.Unlock()
+	delete(r.counts, id)
+}
+
 func (cli *Client) handleEncryptedMessage(node *waBinary.Node) {
 	info, err := cli.parseMessageInfo(node)
 	if err != nil {
@@ -115,8 +145,7 @@ func (cli *Client) decryptMessages(info *types.MessageInfo, node *waBinary.Node)
 	go cli.sendAck(node)
 	if len(node.GetChildrenByTag("unavailable")) == len(node.GetChildren()) {
 		cli.Log.Warnf("Unavailable message %s from %s", info.ID, info.SourceString())
-		go cli.sendRetryReceipt(node, true)
-		cli.dispatchEvent(&events.UndecryptableMessage{Info: *info, IsUnavailable: true})
+		cli.handleDecryptionFailure(node, info, nil, events.ReasonUnavailable, "", true, true)
 		return
 	}
 	children := node.GetChildren()
@@ -126,6 +155,11 @@ func (cli *Client) decryptMessages(info *types.MessageInfo, node *waBinary.Node)
 		if child.Tag != "enc" {
 			continue
 		}
+		preDecryptChild, ok := cli.runPreDecryptHandlers(info, &child)
+		if !ok {
+			continue
+		}
+		child = *preDecryptChild
 		encType, ok := child.Attrs["type"].(string)
 		if !ok {
 			continue
@@ -133,27 +167,33 @@ func (cli *Client) decryptMessages(info *types.MessageInfo, node *waBinary.Node)
 		var decrypted []byte
 		var err error
 		if encType == "pkmsg" || encType == "msg" {
-			decrypted, err = cli.decryptDM(&child, info.Sender, encType == "pkmsg")
+			decrypted, err = cli.decryptDM(node, &child, info.Sender, encType == "pkmsg")
 		} else if info.IsGroup && encType == "skmsg" {
-			decrypted, err = cli.decryptGroupMsg(&child, info.Sender, info.Chat)
+			decrypted, err = cli.decryptGroupMsg(node, &child, info.Sender, info.Chat)
 		} else {
 			cli.Log.Warnf("Unhandled encrypted message (type %s) from %s", encType, info.SourceString())
 			continue
 		}
 		if err != nil {
 			cli.Log.Warnf("Error decrypting message from %s: %v", info.SourceString(), err)
-			go cli.sendRetryReceipt(node, false)
-			cli.dispatchEvent(&events.UndecryptableMessage{Info: *info, IsUnavailable: false})
+			cli.handleDecryptionFailure(node, info, err, classifyDecryptionError(err), encType, false, true)
 			return
 		}
 
+		decrypted, ok = cli.runPostDecryptHandlers(info, decrypted)
+		if !ok {
+			continue
+		}
+
 		var msg waProto.Message
 		err = proto.Unmarshal(decrypted, &msg)
 		if err != nil {
 			cli.Log.Warnf("Error unmarshaling decrypted message from %s: %v", info.SourceString(), err)
+			cli.handleDecryptionFailure(node, info, err, events.ReasonUnmarshalFailed, encType, false, false)
 			continue
 		}
 
+		cli.decryptRetries.clear(info.ID)
 		cli.handleDecryptedMessage(info, &msg)
 		handled = true
 	}
@@ -162,7 +202,66 @@ func (cli *Client) decryptMessages(info *types.MessageInfo, node *waBinary.Node)
 	}
 }
 
-func (cli *Client) decryptDM(child *waBinary.Node, from types.JID, isPreKey bool) ([]byte, error) {
+// classifyDecryptionError makes a best-effort guess at why decryptDM or decryptGroupMsg failed,
+// so it can be attached to UndecryptableMessage/DecryptionGaveUp for consumers that want to
+// react differently depending on the failure (e.g. don't bother retrying a bad identity forever).
+func classifyDecryptionError(err error) events.DecryptionFailureReason {
+	switch {
+	case errors.Is(err, signalerror.ErrUntrustedIdentity):
+		return events.ReasonUntrustedIdentity
+	case errors.Is(err, signalerror.ErrNoSessionFound):
+		return events.ReasonNoSession
+	case errors.Is(err, errInvalidPadding):
+		return events.ReasonBadPadding
+	default:
+		return events.ReasonUnavailable
+	}
+}
+
+// effectiveMaxMessageRetryCount resolves Client.MaxMessageRetryCount, applying
+// defaultMaxMessageRetryCount when it's unset (zero or negative).
+func (cli *Client) effectiveMaxMessageRetryCount() int {
+	if cli.MaxMessageRetryCount <= 0 {
+		return defaultMaxMessageRetryCount
+	}
+	return cli.MaxMessageRetryCount
+}
+
+// handleDecryptionFailure dispatches either an UndecryptableMessage or, once the message has
+// failed often enough, a terminal DecryptionGaveUp instead of retrying forever. It's shared by
+// the unavailable-message, decrypt-error, and unmarshal-error paths in decryptMessages.
+//
+// requestRetry controls whether a retry receipt is sent asking the sender to resend the message.
+// That only makes sense when resending could actually help, i.e. the ciphertext never arrived or
+// failed to decrypt; a message that decrypted fine but didn't parse as a protobuf would just come
+// back identical, and re-requesting an already-consumed ratchet step risks desyncing the session.
+func (cli *Client) handleDecryptionFailure(node *waBinary.Node, info *types.MessageInfo, err error, reason events.DecryptionFailureReason, encType string, isUnavailable, requestRetry bool) {
+	retryCount := cli.decryptRetries.increment(info.ID)
+	if retryCount > cli.effectiveMaxMessageRetryCount() {
+		cli.Log.Warnf("Giving up on decrypting message %s from %s after %d failed attempts", info.ID, info.SourceString(), retryCount)
+		cli.dispatchEvent(&events.DecryptionGaveUp{
+			Info:            *info,
+			DecryptionError: err,
+			Reason:          reason,
+			EncType:         encType,
+			RetryCount:      retryCount,
+		})
+		return
+	}
+	if requestRetry {
+		go cli.sendRetryReceipt(node, isUnavailable)
+	}
+	cli.dispatchEvent(&events.UndecryptableMessage{
+		Info:            *info,
+		IsUnavailable:   isUnavailable,
+		DecryptionError: err,
+		Reason:          reason,
+		EncType:         encType,
+		RetryCount:      retryCount,
+	})
+}
+
+func (cli *Client) decryptDM(node, child *waBinary.Node, from types.JID, isPreKey bool) ([]byte, error) {
 	content, _ := child.Content.([]byte)
 
 	builder := session.NewBuilderFromSignal(cli.Store, from.SignalAddress(), pbSerializer)
@@ -197,13 +296,15 @@ func (cli *Client) decryptDM(child *waBinary.Node, from types.JID, isPreKey bool
 		}
 		plaintext, err = cipher.Decrypt(msg)
 		if err != nil {
+			cli.maybeResetDMSession(node, from, err)
 			return nil, fmt.Errorf("failed to decrypt normal message: %w", err)
 		}
+		cli.sessionFailures.clear(from.SignalAddress().String())
 	}
 	return unpadMessage(plaintext)
 }
 
-func (cli *Client) decryptGroupMsg(child *waBinary.Node, from types.JID, chat types.JID) ([]byte, error) {
+func (cli *Client) decryptGroupMsg(node, child *waBinary.Node, from types.JID, chat types.JID) ([]byte, error) {
 	content, _ := child.Content.([]byte)
 
 	senderKeyName := protocol.NewSenderKeyName(chat.String(), from.SignalAddress())
@@ -215,38 +316,45 @@ func (cli *Client) decryptGroupMsg(child *waBinary.Node, from types.JID, chat ty
 	}
 	plaintext, err := cipher.Decrypt(msg)
 	if err != nil {
+		cli.maybeResetGroupSession(node, chat, from, err)
 		return nil, fmt.Errorf("failed to decrypt group message: %w", err)
 	}
+	cli.sessionFailures.clear(chat.String() + ":" + from.SignalAddress().String())
 	return unpadMessage(plaintext)
 }
 
 const checkPadding = true
 
+// isValidPadding reports whether plaintext ends in a valid padding footer, i.e. its last byte N
+// is repeated N times at the end. It doesn't care which PaddingStrategy produced the padding,
+// since all of them encode their length the same way in the trailing byte.
 func isValidPadding(plaintext []byte) bool {
+	if len(plaintext) == 0 {
+		return false
+	}
 	lastByte := plaintext[len(plaintext)-1]
+	if lastByte == 0 {
+		return false
+	}
 	expectedPadding := bytes.Repeat([]byte{lastByte}, int(lastByte))
 	return bytes.HasSuffix(plaintext, expectedPadding)
 }
 
 func unpadMessage(plaintext []byte) ([]byte, error) {
 	if checkPadding && !isValidPadding(plaintext) {
-		return nil, fmt.Errorf("plaintext doesn't have expected padding")
+		return nil, errInvalidPadding
 	}
 	return plaintext[:len(plaintext)-int(plaintext[len(plaintext)-1])], nil
 }
 
-func padMessage(plaintext []byte) []byte {
-	var pad [1]byte
-	_, err := rand.Read(pad[:])
-	if err != nil {
-		panic(err)
-	}
-	pad[0] &= 0xf
-	if pad[0] == 0 {
-		pad[0] = 0xf
+// padMessage pads plaintext according to cli.PaddingStrategy, defaulting to RandomPadding if
+// none is set.
+func (cli *Client) padMessage(plaintext []byte, chat types.JID) []byte {
+	strategy := cli.PaddingStrategy
+	if strategy == nil {
+		strategy = RandomPadding{}
 	}
-	plaintext = append(plaintext, bytes.Repeat(pad[:], int(pad[0]))...)
-	return plaintext
+	return strategy.Pad(plaintext, chat)
 }
 
 func (cli *Client) handleSenderKeyDistributionMessage(chat, from types.JID, rawSKDMsg *waProto.SenderKeyDistributionMessage) {
@@ -262,50 +370,91 @@ func (cli *Client) handleSenderKeyDistributionMessage(chat, from types.JID, rawS
 }
 
 func (cli *Client) handleHistorySyncNotification(notif *waProto.HistorySyncNotification) {
-	var historySync waProto.HistorySync
-	if data, err := cli.Download(notif); err != nil {
+	data, err := cli.Download(notif)
+	if err != nil {
 		cli.Log.Errorf("Failed to download history sync data: %v", err)
-	} else if reader, err := zlib.NewReader(bytes.NewReader(data)); err != nil {
+		return
+	}
+
+	threshold := cli.HistorySyncStreamThreshold
+	if threshold <= 0 {
+		threshold = defaultHistorySyncStreamThreshold
+	}
+	if len(data) >= threshold {
+		counting := &countingReader{r: bytes.NewReader(data)}
+		reader, err := zlib.NewReader(counting)
+		if err != nil {
+			cli.Log.Errorf("Failed to create zlib reader for history sync data: %v", err)
+			return
+		}
+		defer reader.Close()
+		progress := func() float64 { return float64(counting.n) / float64(len(data)) }
+		if err = cli.streamHistorySync(reader, progress); err != nil {
+			cli.Log.Errorf("Failed to stream history sync data: %v", err)
+		}
+		return
+	}
+
+	var historySync waProto.HistorySync
+	if reader, err := zlib.NewReader(bytes.NewReader(data)); err != nil {
 		cli.Log.Errorf("Failed to create zlib reader for history sync data: %v", err)
-	} else if rawData, err := io.ReadAll(reader); err != nil {
-		cli.Log.Errorf("Failed to decompress history sync data: %v", err)
-	} else if err = proto.Unmarshal(rawData, &historySync); err != nil {
-		cli.Log.Errorf("Failed to unmarshal history sync data: %v", err)
 	} else {
-		cli.Log.Debugf("Received history sync")
-		if historySync.GetSyncType() == waProto.HistorySync_PUSH_NAME {
-			go cli.handleHistoricalPushNames(historySync.GetPushnames())
+		var src io.Reader = reader
+		if cli.HistorySyncStorage != nil {
+			src = io.TeeReader(reader, cli.HistorySyncStorage)
+		}
+		if rawData, err := io.ReadAll(src); err != nil {
+			cli.Log.Errorf("Failed to decompress history sync data: %v", err)
+		} else if err = proto.Unmarshal(rawData, &historySync); err != nil {
+			cli.Log.Errorf("Failed to unmarshal history sync data: %v", err)
+		} else {
+			cli.Log.Debugf("Received history sync")
+			if historySync.GetSyncType() == waProto.HistorySync_PUSH_NAME {
+				go cli.handleHistoricalPushNames(historySync.GetPushnames())
+			}
+			cli.dispatchEvent(&events.HistorySync{
+				Data: &historySync,
+			})
 		}
-		cli.dispatchEvent(&events.HistorySync{
-			Data: &historySync,
-		})
 	}
 }
 
 func (cli *Client) handleAppStateSyncKeyShare(keys *waProto.AppStateSyncKeyShare) {
 	cli.Log.Debugf("Got %d new app state keys", len(keys.GetKeys()))
 	for _, key := range keys.GetKeys() {
+		keyID := key.GetKeyId().GetKeyId()
+		existingKey, err := cli.Store.AppStateKeys.GetAppStateSyncKey(keyID)
+		isExisting := err == nil && existingKey != nil
+
 		marshaledFingerprint, err := proto.Marshal(key.GetKeyData().GetFingerprint())
 		if err != nil {
-			cli.Log.Errorf("Failed to marshal fingerprint of app state sync key %X", key.GetKeyId().GetKeyId())
+			cli.Log.Errorf("Failed to marshal fingerprint of app state sync key %X", keyID)
 			continue
 		}
-		err = cli.Store.AppStateKeys.PutAppStateSyncKey(key.GetKeyId().GetKeyId(), store.AppStateSyncKey{
+		err = cli.Store.AppStateKeys.PutAppStateSyncKey(keyID, store.AppStateSyncKey{
 			Data:        key.GetKeyData().GetKeyData(),
 			Fingerprint: marshaledFingerprint,
 			Timestamp:   key.GetKeyData().GetTimestamp(),
 		})
 		if err != nil {
-			cli.Log.Errorf("Failed to store app state sync key %X", key.GetKeyId().GetKeyId())
+			cli.Log.Errorf("Failed to store app state sync key %X", keyID)
 			continue
 		}
-		cli.Log.Debugf("Received app state sync key %X", key.GetKeyId().GetKeyId())
+		cli.Log.Debugf("Received app state sync key %X", keyID)
+		cli.dispatchEvent(&events.AppStateSyncKeyReceived{
+			KeyID:       keyID,
+			Fingerprint: marshaledFingerprint,
+			Timestamp:   key.GetKeyData().GetTimestamp(),
+			IsNew:       !isExisting,
+		})
 	}
 
-	for _, name := range appstate.AllPatchNames {
-		err := cli.FetchAppState(name, false, true)
-		if err != nil {
-			cli.Log.Errorf("Failed to do initial fetch of app state %s: %v", name, err)
+	if !cli.DisableAutoFetchAppStateOnKeyShare {
+		for _, name := range appstate.AllPatchNames {
+			err := cli.FetchAppState(name, false, true)
+			if err != nil {
+				cli.Log.Errorf("Failed to do initial fetch of app state %s: %v", name, err)
+			}
 		}
 	}
 }
@@ -360,6 +509,12 @@ func (cli *Client) handleDecryptedMessage(info *types.MessageInfo, msg *waProto.
 		msg = msg.GetViewOnceMessage().GetMessage()
 		evt.IsViewOnce = true
 	}
+
+	var ok bool
+	msg, ok = cli.runMessageFilters(info, msg)
+	if !ok {
+		return
+	}
 	evt.Message = msg
 
 	cli.dispatchEvent(evt)
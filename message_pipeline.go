@@ -0,0 +1,176 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"sync"
+
+	waBinary "go.mau.fi/whatsmeow/binary"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+// PreDecryptHandler is called with the raw `enc` node of an incoming message before it's
+// decrypted. It may return a replacement node, or ok=false to drop the node without decrypting
+// it (e.g. to implement a per-JID mute list). The message stanza has already been acked by then,
+// but a dropped node doesn't count as handled for the delivery receipt.
+type PreDecryptHandler func(info *types.MessageInfo, node *waBinary.Node) (*waBinary.Node, bool)
+
+// PostDecryptHandler is called with the decrypted plaintext of an incoming message before it's
+// unmarshaled into a protobuf message. It may return replacement plaintext, or ok=false to drop
+// the message (e.g. to archive the raw bytes before they're parsed and discarded).
+type PostDecryptHandler func(info *types.MessageInfo, plaintext []byte) ([]byte, bool)
+
+// MessageFilter is called once per message, after whatsmeow has unwrapped any
+// DeviceSentMessage/EphemeralMessage/ViewOnceMessage wrapper, with the final message that would
+// otherwise be dispatched as an events.Message. It may return a replacement message, or ok=false
+// to drop it before it's dispatched (e.g. for server-side spam filtering or transparent
+// decryption of custom payloads).
+type MessageFilter func(info *types.MessageInfo, msg *waProto.Message) (*waProto.Message, bool)
+
+type preDecryptEntry struct {
+	id      uint32
+	handler PreDecryptHandler
+}
+
+type postDecryptEntry struct {
+	id      uint32
+	handler PostDecryptHandler
+}
+
+type messageFilterEntry struct {
+	id     uint32
+	filter MessageFilter
+}
+
+// messagePipeline holds the user-registered stages that decryptMessages and
+// handleDecryptedMessage run incoming messages through, in registration order.
+type messagePipeline struct {
+	sync.RWMutex
+	nextHandlerID uint32
+
+	preDecrypt  []preDecryptEntry
+	postDecrypt []postDecryptEntry
+	filters     []messageFilterEntry
+}
+
+// AddPreDecryptHandler registers a handler that runs on the raw ciphertext node of every
+// incoming message before it's decrypted. The returned ID can be used with
+// RemovePreDecryptHandler to unregister it later.
+func (cli *Client) AddPreDecryptHandler(handler PreDecryptHandler) uint32 {
+	cli.pipeline.Lock()
+	defer cli.pipeline.Unlock()
+	cli.pipeline.nextHandlerID++
+	id := cli.pipeline.nextHandlerID
+	cli.pipeline.preDecrypt = append(cli.pipeline.preDecrypt, preDecryptEntry{id, handler})
+	return id
+}
+
+// RemovePreDecryptHandler removes a handler previously registered with AddPreDecryptHandler.
+func (cli *Client) RemovePreDecryptHandler(id uint32) {
+	cli.pipeline.Lock()
+	defer cli.pipeline.Unlock()
+	for i, entry := range cli.pipeline.preDecrypt {
+		if entry.id == id {
+			cli.pipeline.preDecrypt = append(cli.pipeline.preDecrypt[:i], cli.pipeline.preDecrypt[i+1:]...)
+			break
+		}
+	}
+}
+
+// AddPostDecryptHandler registers a handler that runs on the decrypted plaintext of every
+// incoming message before it's unmarshaled. The returned ID can be used with
+// RemovePostDecryptHandler to unregister it later.
+func (cli *Client) AddPostDecryptHandler(handler PostDecryptHandler) uint32 {
+	cli.pipeline.Lock()
+	defer cli.pipeline.Unlock()
+	cli.pipeline.nextHandlerID++
+	id := cli.pipeline.nextHandlerID
+	cli.pipeline.postDecrypt = append(cli.pipeline.postDecrypt, postDecryptEntry{id, handler})
+	return id
+}
+
+// RemovePostDecryptHandler removes a handler previously registered with AddPostDecryptHandler.
+func (cli *Client) RemovePostDecryptHandler(id uint32) {
+	cli.pipeline.Lock()
+	defer cli.pipeline.Unlock()
+	for i, entry := range cli.pipeline.postDecrypt {
+		if entry.id == id {
+			cli.pipeline.postDecrypt = append(cli.pipeline.postDecrypt[:i], cli.pipeline.postDecrypt[i+1:]...)
+			break
+		}
+	}
+}
+
+// AddMessageFilter registers a filter that runs once per message, after whatsmeow has unwrapped
+// any DeviceSentMessage/EphemeralMessage/ViewOnceMessage wrapper. The returned ID can be used
+// with RemoveMessageFilter to unregister it later.
+func (cli *Client) AddMessageFilter(filter MessageFilter) uint32 {
+	cli.pipeline.Lock()
+	defer cli.pipeline.Unlock()
+	cli.pipeline.nextHandlerID++
+	id := cli.pipeline.nextHandlerID
+	cli.pipeline.filters = append(cli.pipeline.filters, messageFilterEntry{id, filter})
+	return id
+}
+
+// RemoveMessageFilter removes a filter previously registered with AddMessageFilter.
+func (cli *Client) RemoveMessageFilter(id uint32) {
+	cli.pipeline.Lock()
+	defer cli.pipeline.Unlock()
+	for i, entry := range cli.pipeline.filters {
+		if entry.id == id {
+			cli.pipeline.filters = append(cli.pipeline.filters[:i], cli.pipeline.filters[i+1:]...)
+			break
+		}
+	}
+}
+
+// runPreDecryptHandlers runs the registered PreDecryptHandlers in registration order, returning
+// ok=false as soon as one of them drops the node.
+func (cli *Client) runPreDecryptHandlers(info *types.MessageInfo, node *waBinary.Node) (*waBinary.Node, bool) {
+	cli.pipeline.RLock()
+	defer cli.pipeline.RUnlock()
+	ok := true
+	for _, entry := range cli.pipeline.preDecrypt {
+		node, ok = entry.handler(info, node)
+		if !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// runPostDecryptHandlers runs the registered PostDecryptHandlers in registration order,
+// returning ok=false as soon as one of them drops the plaintext.
+func (cli *Client) runPostDecryptHandlers(info *types.MessageInfo, plaintext []byte) ([]byte, bool) {
+	cli.pipeline.RLock()
+	defer cli.pipeline.RUnlock()
+	ok := true
+	for _, entry := range cli.pipeline.postDecrypt {
+		plaintext, ok = entry.handler(info, plaintext)
+		if !ok {
+			return nil, false
+		}
+	}
+	return plaintext, true
+}
+
+// runMessageFilters runs the registered MessageFilters in registration order, returning
+// ok=false as soon as one of them drops the message.
+func (cli *Client) runMessageFilters(info *types.MessageInfo, msg *waProto.Message) (*waProto.Message, bool) {
+	cli.pipeline.RLock()
+	defer cli.pipeline.RUnlock()
+	ok := true
+	for _, entry := range cli.pipeline.filters {
+		msg, ok = entry.filter(info, msg)
+		if !ok {
+			return nil, false
+		}
+	}
+	return msg, true
+}
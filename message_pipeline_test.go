@@ -0,0 +1,105 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"testing"
+
+	waBinary "go.mau.fi/whatsmeow/binary"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"go.mau.fi/whatsmeow/types"
+)
+
+func TestPreDecryptHandlersRunInOrder(t *testing.T) {
+	cli := &Client{}
+	var order []string
+	cli.AddPreDecryptHandler(func(info *types.MessageInfo, node *waBinary.Node) (*waBinary.Node, bool) {
+		order = append(order, "first")
+		return node, true
+	})
+	cli.AddPreDecryptHandler(func(info *types.MessageInfo, node *waBinary.Node) (*waBinary.Node, bool) {
+		order = append(order, "second")
+		return node, true
+	})
+	node := &waBinary.Node{Tag: "enc"}
+	out, ok := cli.runPreDecryptHandlers(&types.MessageInfo{}, node)
+	if !ok || out != node {
+		t.Fatalf("runPreDecryptHandlers = (%v, %v), want (%v, true)", out, ok, node)
+	}
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("handlers ran in order %v, want [first second]", order)
+	}
+}
+
+func TestPreDecryptHandlersStopOnDrop(t *testing.T) {
+	cli := &Client{}
+	ran := false
+	cli.AddPreDecryptHandler(func(info *types.MessageInfo, node *waBinary.Node) (*waBinary.Node, bool) {
+		return nil, false
+	})
+	cli.AddPreDecryptHandler(func(info *types.MessageInfo, node *waBinary.Node) (*waBinary.Node, bool) {
+		ran = true
+		return node, true
+	})
+	_, ok := cli.runPreDecryptHandlers(&types.MessageInfo{}, &waBinary.Node{})
+	if ok {
+		t.Error("runPreDecryptHandlers returned ok=true after a handler dropped the node")
+	}
+	if ran {
+		t.Error("a handler after the one that dropped the node still ran")
+	}
+}
+
+func TestRemovePreDecryptHandler(t *testing.T) {
+	cli := &Client{}
+	ran := false
+	id := cli.AddPreDecryptHandler(func(info *types.MessageInfo, node *waBinary.Node) (*waBinary.Node, bool) {
+		ran = true
+		return node, true
+	})
+	cli.RemovePreDecryptHandler(id)
+	if _, ok := cli.runPreDecryptHandlers(&types.MessageInfo{}, &waBinary.Node{}); !ok {
+		t.Fatal("runPreDecryptHandlers returned ok=false with no handlers registered")
+	}
+	if ran {
+		t.Error("removed handler still ran")
+	}
+}
+
+func TestPostDecryptHandlersCanReplacePlaintext(t *testing.T) {
+	cli := &Client{}
+	cli.AddPostDecryptHandler(func(info *types.MessageInfo, plaintext []byte) ([]byte, bool) {
+		return append(plaintext, '!'), true
+	})
+	out, ok := cli.runPostDecryptHandlers(&types.MessageInfo{}, []byte("hi"))
+	if !ok {
+		t.Fatal("runPostDecryptHandlers returned ok=false")
+	}
+	if string(out) != "hi!" {
+		t.Errorf("runPostDecryptHandlers = %q, want %q", out, "hi!")
+	}
+}
+
+func TestMessageFiltersRunOnceAndCanDrop(t *testing.T) {
+	cli := &Client{}
+	runs := 0
+	cli.AddMessageFilter(func(info *types.MessageInfo, msg *waProto.Message) (*waProto.Message, bool) {
+		runs++
+		return nil, false
+	})
+	cli.AddMessageFilter(func(info *types.MessageInfo, msg *waProto.Message) (*waProto.Message, bool) {
+		t.Error("filter after the dropping one should not run")
+		return msg, true
+	})
+	_, ok := cli.runMessageFilters(&types.MessageInfo{}, &waProto.Message{})
+	if ok {
+		t.Error("runMessageFilters returned ok=true after a filter dropped the message")
+	}
+	if runs != 1 {
+		t.Errorf("dropping filter ran %d times, want 1", runs)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"crypto/rand"
+	"math"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// PaddingStrategy produces the padding bytes appended to a plaintext message before encryption.
+// unpadMessage only ever looks at the trailing byte to find out how many bytes to strip, so the
+// sender's choice of strategy needs no coordination with the receiver: any implementation that
+// repeats its pad-length byte that many times at the end of the plaintext works with every
+// whatsmeow receiver, past or future.
+type PaddingStrategy interface {
+	// Pad returns plaintext with padding appended. chat is the destination, for strategies that
+	// vary by chat, like FixedBlockPadding's PerChat.
+	Pad(plaintext []byte, chat types.JID) []byte
+}
+
+// RandomPadding is the original whatsmeow padding scheme: 1-15 random bytes, PKCS7-style. It's
+// simple but leaks the plaintext length to within a 16-byte bucket.
+type RandomPadding struct{}
+
+func (RandomPadding) Pad(plaintext []byte, _ types.JID) []byte {
+	var b [1]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[0] &= 0xf
+	if b[0] == 0 {
+		b[0] = 0xf
+	}
+	return padWithLength(plaintext, b[0])
+}
+
+// FixedBlockPadding pads up to the next multiple of a fixed block size, trading precise
+// length-hiding for a deterministic, easily-reasoned-about message size. BlockSize is used for
+// chats not present in PerChat. Both must be in 1-255, since the pad length has to fit in the
+// single trailing length byte.
+type FixedBlockPadding struct {
+	BlockSize int
+	PerChat   map[types.JID]int
+}
+
+func (f FixedBlockPadding) Pad(plaintext []byte, chat types.JID) []byte {
+	blockSize := f.BlockSize
+	if n, ok := f.PerChat[chat]; ok {
+		blockSize = n
+	}
+	if blockSize <= 0 || blockSize > 255 {
+		blockSize = 16
+	}
+	padLen := blockSize - len(plaintext)%blockSize
+	if padLen == 0 {
+		padLen = blockSize
+	}
+	return padWithLength(plaintext, byte(padLen))
+}
+
+// PadmePadding implements the PADMÉ scheme (https://lbarman.ch/blog/padme/), padding lengths to
+// 2^k * (1 + m/2^E) for logarithmic overhead while hiding most of the exact plaintext length.
+// The padding length is capped at 255 bytes to fit in the trailing length byte, so very large
+// messages whose PADMÉ target is more than 255 bytes away get 255 bytes of padding and don't
+// land on a PADMÉ length.
+type PadmePadding struct{}
+
+func (PadmePadding) Pad(plaintext []byte, _ types.JID) []byte {
+	padLen := padmeLength(len(plaintext)+1) - len(plaintext)
+	if padLen <= 0 {
+		padLen = 1
+	} else if padLen > 255 {
+		padLen = 255
+	}
+	return padWithLength(plaintext, byte(padLen))
+}
+
+// padmeLength computes the PADMÉ target length for a message of the given length.
+func padmeLength(l int) int {
+	if l <= 2 {
+		return l
+	}
+	e := int(math.Floor(math.Log2(float64(l))))
+	s := int(math.Floor(math.Log2(float64(e)))) + 1
+	lastBits := e - s
+	bitMask := (1 << lastBits) - 1
+	return (l + bitMask) &^ bitMask
+}
+
+func padWithLength(plaintext []byte, padLen byte) []byte {
+	return append(plaintext, bytes.Repeat([]byte{padLen}, int(padLen))...)
+}
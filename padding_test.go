@@ -0,0 +1,29 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import "testing"
+
+func FuzzIsValidPadding(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0x00})
+	f.Add([]byte{0x01, 0x01})
+	f.Add([]byte{0x05, 0x05, 0x05, 0x05, 0x05})
+	f.Add(padWithLength([]byte("hello"), 0x0f))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// isValidPadding must never panic, regardless of input.
+		isValidPadding(data)
+	})
+}
+
+func BenchmarkIsValidPadding(b *testing.B) {
+	data := padWithLength(make([]byte, 256), 0x10)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		isValidPadding(data)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"fmt"
+	"io"
+)
+
+// Protobuf wire types, see https://protobuf.dev/programming-guides/encoding/#structure
+const (
+	wireTypeVarint  = 0
+	wireTypeFixed64 = 1
+	wireTypeBytes   = 2
+	wireTypeFixed32 = 5
+)
+
+// wireReader does a minimal, streaming, top-level-only walk of a protobuf message's wire format.
+// It's used by streamHistorySync to pull individual length-delimited submessages out of a large
+// HistorySync payload without ever unmarshaling (or buffering) the whole thing at once.
+type wireReader struct {
+	r     io.Reader
+	byte1 [1]byte
+	// scratch is reused across readBytes calls to avoid a new allocation per submessage; it's
+	// replaced with a larger buffer as needed and the caller must not retain the returned slice.
+	scratch []byte
+}
+
+func (wr *wireReader) readByte() (byte, error) {
+	if _, err := io.ReadFull(wr.r, wr.byte1[:]); err != nil {
+		return 0, err
+	}
+	return wr.byte1[0], nil
+}
+
+func (wr *wireReader) readVarint() (uint64, error) {
+	var result uint64
+	var shift uint
+	for {
+		b, err := wr.readByte()
+		if err != nil {
+			return 0, err
+		}
+		result |= uint64(b&0x7f) << shift
+		if b&0x80 == 0 {
+			return result, nil
+		}
+		shift += 7
+		if shift >= 64 {
+			return 0, fmt.Errorf("varint too long")
+		}
+	}
+}
+
+// readTag reads a field tag and returns the field number and wire type. It returns io.EOF if
+// the underlying reader is exhausted exactly at a field boundary.
+func (wr *wireReader) readTag() (fieldNum int, wireType int, err error) {
+	tag, err := wr.readVarint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(tag >> 3), int(tag & 0x7), nil
+}
+
+// readBytes reads a length-delimited field into wr's scratch buffer and returns it. The returned
+// slice is only valid until the next call to readBytes.
+func (wr *wireReader) readBytes() ([]byte, error) {
+	length, err := wr.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(cap(wr.scratch)) < length {
+		wr.scratch = make([]byte, length)
+	}
+	wr.scratch = wr.scratch[:length]
+	if _, err = io.ReadFull(wr.r, wr.scratch); err != nil {
+		return nil, err
+	}
+	return wr.scratch, nil
+}
+
+// skipField reads and discards a field's value given its wire type, without knowing its number
+// or semantics. Group-encoded fields (deprecated wire types 3/4) aren't supported since they
+// don't appear in the HistorySync schema.
+func (wr *wireReader) skipField(wireType int) error {
+	switch wireType {
+	case wireTypeVarint:
+		_, err := wr.readVarint()
+		return err
+	case wireTypeBytes:
+		_, err := wr.readBytes()
+		return err
+	case wireTypeFixed64:
+		var buf [8]byte
+		_, err := io.ReadFull(wr.r, buf[:])
+		return err
+	case wireTypeFixed32:
+		var buf [4]byte
+		_, err := io.ReadFull(wr.r, buf[:])
+		return err
+	default:
+		return fmt.Errorf("unsupported wire type %d", wireType)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestWireReaderReadVarint(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []byte
+		want uint64
+	}{
+		{"zero", []byte{0x00}, 0},
+		{"oneByte", []byte{0x7f}, 127},
+		{"twoBytes", []byte{0x96, 0x01}, 150},
+		{"maxUint32", []byte{0xff, 0xff, 0xff, 0xff, 0x0f}, 0xffffffff},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			wr := &wireReader{r: bytes.NewReader(tc.in)}
+			got, err := wr.readVarint()
+			if err != nil {
+				t.Fatalf("readVarint returned error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("readVarint = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWireReaderReadVarintTooLong(t *testing.T) {
+	wr := &wireReader{r: bytes.NewReader(bytes.Repeat([]byte{0x80}, 10))}
+	if _, err := wr.readVarint(); err == nil {
+		t.Error("expected an error for a varint with too many continuation bytes, got nil")
+	}
+}
+
+func TestWireReaderReadTag(t *testing.T) {
+	// Field 2, wire type 2 (length-delimited): tag = (2 << 3) | 2 = 18 = 0x12.
+	wr := &wireReader{r: bytes.NewReader([]byte{0x12})}
+	fieldNum, wireType, err := wr.readTag()
+	if err != nil {
+		t.Fatalf("readTag returned error: %v", err)
+	}
+	if fieldNum != 2 || wireType != wireTypeBytes {
+		t.Errorf("readTag = (%d, %d), want (2, %d)", fieldNum, wireType, wireTypeBytes)
+	}
+}
+
+func TestWireReaderReadTagEOF(t *testing.T) {
+	wr := &wireReader{r: bytes.NewReader(nil)}
+	if _, _, err := wr.readTag(); err != io.EOF {
+		t.Errorf("readTag on empty input = %v, want io.EOF", err)
+	}
+}
+
+func TestWireReaderReadBytes(t *testing.T) {
+	// Length-delimited field containing "hi": length 2 followed by the bytes.
+	wr := &wireReader{r: bytes.NewReader([]byte{0x02, 'h', 'i'})}
+	got, err := wr.readBytes()
+	if err != nil {
+		t.Fatalf("readBytes returned error: %v", err)
+	}
+	if string(got) != "hi" {
+		t.Errorf("readBytes = %q, want %q", got, "hi")
+	}
+}
+
+func TestWireReaderReadBytesReusesScratch(t *testing.T) {
+	wr := &wireReader{r: bytes.NewReader([]byte{0x03, 'a', 'b', 'c', 0x01, 'z'})}
+	first, err := wr.readBytes()
+	if err != nil {
+		t.Fatalf("first readBytes returned error: %v", err)
+	}
+	if string(first) != "abc" {
+		t.Fatalf("first readBytes = %q, want %q", first, "abc")
+	}
+	second, err := wr.readBytes()
+	if err != nil {
+		t.Fatalf("second readBytes returned error: %v", err)
+	}
+	if string(second) != "z" {
+		t.Errorf("second readBytes = %q, want %q", second, "z")
+	}
+}
+
+func TestWireReaderSkipField(t *testing.T) {
+	tests := []struct {
+		name     string
+		wireType int
+		in       []byte
+		wantLeft byte
+	}{
+		{"varint", wireTypeVarint, []byte{0x96, 0x01, 0xff}, 0xff},
+		{"bytes", wireTypeBytes, []byte{0x02, 'h', 'i', 0xff}, 0xff},
+		{"fixed64", wireTypeFixed64, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0xff}, 0xff},
+		{"fixed32", wireTypeFixed32, []byte{0, 0, 0, 0, 0xff}, 0xff},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			wr := &wireReader{r: bytes.NewReader(tc.in)}
+			if err := wr.skipField(tc.wireType); err != nil {
+				t.Fatalf("skipField returned error: %v", err)
+			}
+			rest, err := io.ReadAll(wr.r)
+			if err != nil {
+				t.Fatalf("failed to read remaining bytes: %v", err)
+			}
+			if len(rest) != 1 || rest[0] != tc.wantLeft {
+				t.Errorf("bytes left after skipField = %v, want [%d]", rest, tc.wantLeft)
+			}
+		})
+	}
+}
+
+func TestWireReaderSkipFieldUnsupportedWireType(t *testing.T) {
+	wr := &wireReader{r: bytes.NewReader(nil)}
+	if err := wr.skipField(3); err == nil {
+		t.Error("expected an error for an unsupported (group) wire type, got nil")
+	}
+}
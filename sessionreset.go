@@ -0,0 +1,145 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	waBinary "go.mau.fi/whatsmeow/binary"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// SessionResetPolicy configures automatic recovery from a Signal session that's stuck failing to
+// decrypt post-handshake ("msg"/"skmsg") messages from the same peer over and over. This is
+// distinct from the untrusted-identity retry in decryptDM: that covers a prekey message being
+// rejected outright, while this covers an established session whose normal messages keep failing
+// to decrypt.
+type SessionResetPolicy struct {
+	// MaxConsecutiveFailures is how many decryption failures in a row (within ResetWindow) from
+	// the same address trigger a session reset. Zero disables automatic resets.
+	MaxConsecutiveFailures int
+	// ResetWindow bounds how long a streak of failures is allowed to span before it's treated as
+	// a new streak instead of a continuation of the old one. Zero means no time limit.
+	ResetWindow time.Duration
+	// OnReset, if set, is called synchronously right before the session (or sender key) is
+	// deleted.
+	OnReset func(types.JID)
+}
+
+type sessionFailureEntry struct {
+	count       int
+	windowStart time.Time
+}
+
+// sessionFailureTracker counts consecutive decryption failures per Signal address so
+// SessionResetPolicy can trip after MaxConsecutiveFailures within ResetWindow.
+type sessionFailureTracker struct {
+	sync.Mutex
+	entries map[string]*sessionFailureEntry
+}
+
+func (t *sessionFailureTracker) record(key string, window time.Duration) int {
+	t.Lock()
+	defer t.Unlock()
+	if t.entries == nil {
+		t.entries = make(map[string]*sessionFailureEntry)
+	}
+	entry, ok := t.entries[key]
+	now := time.Now()
+	if !ok || (window > 0 && now.Sub(entry.windowStart) > window) {
+		entry = &sessionFailureEntry{windowStart: now}
+		t.entries[key] = entry
+	}
+	entry.count++
+	return entry.count
+}
+
+func (t *sessionFailureTracker) clear(key string) {
+	t.Lock()
+	defer t.Unlock()
+	delete(t.entries, key)
+}
+
+// maybeResetDMSession records a normal-message decryption failure against from and, once
+// Client.SessionResetPolicy trips, deletes the Signal session and requests a fresh one.
+func (cli *Client) maybeResetDMSession(node *waBinary.Node, from types.JID, cause error) {
+	policy := cli.SessionResetPolicy
+	if policy.MaxConsecutiveFailures <= 0 {
+		return
+	}
+	addr := from.SignalAddress().String()
+	count := cli.sessionFailures.record(addr, policy.ResetWindow)
+	if count < policy.MaxConsecutiveFailures {
+		return
+	}
+	cli.sessionFailures.clear(addr)
+	if policy.OnReset != nil {
+		policy.OnReset(from)
+	}
+	cli.Log.Warnf("Resetting session with %s after %d consecutive decryption failures", from, count)
+	if err := cli.Store.Sessions.DeleteSession(addr); err != nil {
+		cli.Log.Warnf("Failed to delete session with %s during automatic reset: %v", from, err)
+	}
+	cli.dispatchEvent(&events.SessionReset{
+		JID:       from,
+		Reason:    "repeated decryption failures for normal messages",
+		LastError: cause,
+	})
+	cli.requestFreshSession(node, from, false)
+}
+
+// maybeResetGroupSession is the group-chat equivalent of maybeResetDMSession. A stuck group
+// session is usually a stale sender key rather than a stale pairwise session, so it clears the
+// stored sender key for from in chat instead of a Signal session.
+func (cli *Client) maybeResetGroupSession(node *waBinary.Node, chat, from types.JID, cause error) {
+	policy := cli.SessionResetPolicy
+	if policy.MaxConsecutiveFailures <= 0 {
+		return
+	}
+	key := chat.String() + ":" + from.SignalAddress().String()
+	count := cli.sessionFailures.record(key, policy.ResetWindow)
+	if count < policy.MaxConsecutiveFailures {
+		return
+	}
+	cli.sessionFailures.clear(key)
+	if policy.OnReset != nil {
+		policy.OnReset(from)
+	}
+	cli.Log.Warnf("Resetting sender key from %s in %s after %d consecutive decryption failures", from, chat, count)
+	if err := cli.Store.SenderKeys.DeleteSenderKey(chat.String(), from.SignalAddress().String()); err != nil {
+		cli.Log.Warnf("Failed to delete sender key from %s in %s during automatic reset: %v", from, chat, err)
+	}
+	cli.dispatchEvent(&events.SessionReset{
+		JID:       from,
+		Chat:      chat,
+		Reason:    "repeated decryption failures for group messages",
+		LastError: cause,
+	})
+	cli.requestFreshSession(node, from, true)
+}
+
+// requestFreshSession asks from to rebuild its side of the session by sending a retry receipt
+// for node, the message whose decryption tripped the reset, mirroring what official clients do
+// when they detect an unrecoverable session: the peer reacts to the retry by resending with a
+// fresh SenderKeyDistributionMessage (groups) or a fresh prekey message (DMs), rather than
+// leaving the reset inert until we happen to send an outgoing message of our own. For DMs it
+// also fetches jid's prekey bundle; the fetched bundle is neither stored nor used to build a
+// session here.
+func (cli *Client) requestFreshSession(node *waBinary.Node, jid types.JID, isGroup bool) {
+	go cli.sendRetryReceipt(node, false)
+	if isGroup {
+		return
+	}
+	go func() {
+		if _, err := cli.fetchPreKeys(context.Background(), []types.JID{jid}); err != nil {
+			cli.Log.Warnf("Failed to fetch fresh prekey bundle for %s after session reset: %v", jid, err)
+		}
+	}()
+}
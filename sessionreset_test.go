@@ -0,0 +1,53 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package whatsmeow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionFailureTrackerCounts(t *testing.T) {
+	var tracker sessionFailureTracker
+	if n := tracker.record("a", 0); n != 1 {
+		t.Errorf("first record = %d, want 1", n)
+	}
+	if n := tracker.record("a", 0); n != 2 {
+		t.Errorf("second record = %d, want 2", n)
+	}
+	if n := tracker.record("b", 0); n != 1 {
+		t.Errorf("record for a different key = %d, want 1", n)
+	}
+}
+
+func TestSessionFailureTrackerClear(t *testing.T) {
+	var tracker sessionFailureTracker
+	tracker.record("a", 0)
+	tracker.record("a", 0)
+	tracker.clear("a")
+	if n := tracker.record("a", 0); n != 1 {
+		t.Errorf("record after clear = %d, want 1", n)
+	}
+}
+
+func TestSessionFailureTrackerWindowReset(t *testing.T) {
+	var tracker sessionFailureTracker
+	tracker.record("a", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if n := tracker.record("a", time.Millisecond); n != 1 {
+		t.Errorf("record after window expired = %d, want 1 (new streak)", n)
+	}
+}
+
+func TestSessionFailureTrackerNoWindowNeverExpires(t *testing.T) {
+	var tracker sessionFailureTracker
+	tracker.record("a", 0)
+	time.Sleep(5 * time.Millisecond)
+	if n := tracker.record("a", 0); n != 2 {
+		t.Errorf("record with window=0 after a delay = %d, want 2 (no expiry)", n)
+	}
+}
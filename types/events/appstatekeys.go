@@ -0,0 +1,20 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package events
+
+// AppStateSyncKeyReceived is emitted once per app state sync key received via a companion
+// device's key share, whether that share was triggered automatically or via
+// Client.RequestAppStateKeys. Consumers that want to mirror keys to another device, audit
+// rotations, or otherwise track the key lifecycle out-of-band should listen for this instead of
+// (or in addition to) relying on the side effects of FetchAppState.
+type AppStateSyncKeyReceived struct {
+	KeyID       []byte
+	Fingerprint []byte
+	Timestamp   int64
+	// IsNew is true if whatsmeow didn't already have a key with this ID stored.
+	IsNew bool
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package events
+
+import "go.mau.fi/whatsmeow/types"
+
+// DecryptionFailureReason is a rough classification of why a message failed to decrypt.
+//
+// It's derived on a best-effort basis from the underlying libsignal/protobuf error, so
+// consumers shouldn't assume it's exhaustive or always accurate.
+type DecryptionFailureReason string
+
+const (
+	ReasonUnavailable       DecryptionFailureReason = "unavailable"
+	ReasonNoSession         DecryptionFailureReason = "no_session"
+	ReasonBadPadding        DecryptionFailureReason = "bad_padding"
+	ReasonUntrustedIdentity DecryptionFailureReason = "untrusted_identity"
+	ReasonUnmarshalFailed   DecryptionFailureReason = "unmarshal_failed"
+	ReasonUnknownEncType    DecryptionFailureReason = "unknown_enc_type"
+)
+
+// UndecryptableMessage is emitted when receiving a new message that failed to decrypt.
+type UndecryptableMessage struct {
+	Info types.MessageInfo
+
+	IsUnavailable bool
+
+	// DecryptionError is the error that caused decryption to fail, if any.
+	// It's nil when IsUnavailable is true and the sender never even sent ciphertext.
+	DecryptionError error
+	// Reason is a rough classification of DecryptionError.
+	Reason DecryptionFailureReason
+	// EncType is the `type` attribute of the `enc` child that failed to decrypt (e.g. "pkmsg", "msg", "skmsg").
+	EncType string
+	// RetryCount is how many times decryption of this message ID has failed so far, including this failure.
+	RetryCount int
+}
+
+// DecryptionGaveUp is emitted instead of UndecryptableMessage when a message has failed to decrypt
+// more times than Client.MaxMessageRetryCount and whatsmeow has stopped requesting retries for it.
+//
+// Consumers (e.g. bridges) should treat the message as permanently lost and may want to post a
+// placeholder in its place rather than waiting for a retry that will never come.
+type DecryptionGaveUp struct {
+	Info types.MessageInfo
+
+	DecryptionError error
+	Reason          DecryptionFailureReason
+	EncType         string
+	RetryCount      int
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package events
+
+import waProto "go.mau.fi/whatsmeow/binary/proto"
+
+// HistorySyncChunk is emitted instead of HistorySync when a history sync payload is big enough
+// to be streamed (see Client.HistorySyncStreamThreshold). It's dispatched once per chunk of
+// conversations as they're parsed off the wire, rather than once for the whole decoded tree.
+type HistorySyncChunk struct {
+	SyncType waProto.HistorySync_HistorySyncType
+	// ChunkIndex is a 1-indexed counter of how many chunks have been dispatched for this sync so far.
+	ChunkIndex int
+	// TotalConversations is the number of conversations parsed so far across all chunks of this
+	// sync, including this one. The grand total isn't known until the sync finishes, so this is
+	// a running count, not a final size.
+	TotalConversations int
+	// ConversationsInChunk contains the conversations parsed for this chunk specifically.
+	ConversationsInChunk []*waProto.Conversation
+	// Progress is a rough estimate (0-1) of how much of the compressed history sync payload has
+	// been consumed so far. It's based on compressed bytes read, not decoded message count, so
+	// it's only an approximation.
+	Progress float64
+}
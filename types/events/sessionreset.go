@@ -0,0 +1,25 @@
+// Copyright (c) 2023 Tulir Asokan
+//
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package events
+
+import "go.mau.fi/whatsmeow/types"
+
+// SessionReset is emitted when whatsmeow automatically deletes a Signal session (or, for group
+// chats, a sender key) after too many consecutive decryption failures with the same peer. See
+// Client.SessionResetPolicy for the thresholds that control this.
+type SessionReset struct {
+	// JID is the peer whose session (or sender key) was reset. For group chats this is the
+	// sender, not the group.
+	JID types.JID
+	// Chat is the group the reset sender key belonged to. It's empty for DM session resets,
+	// since a given JID can only have one DM session but many independent per-group sender keys.
+	Chat types.JID
+	// Reason is a short human-readable description of why the session was reset.
+	Reason string
+	// LastError is the decryption error that brought the failure count up to the threshold.
+	LastError error
+}